@@ -0,0 +1,380 @@
+package protocol
+
+/*
+Protocol implements the CoSi collective signing protocol: the root
+announces one or more messages to every node in the tree, the leaves
+commit, the root aggregates the commitments into one challenge per
+message, every node responds with its share of each, and the root
+aggregates the responses into one final Schnorr-like signature per
+message. Batching N messages into the same commit/challenge/response
+round costs the same single round-trip as signing one.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dedis/kyber"
+	"github.com/dedis/kyber/sign/cosi"
+	"github.com/dedis/onet"
+	"github.com/dedis/onet/log"
+)
+
+func init() {
+	_, _ = onet.GlobalProtocolRegister(DefaultProtocolName, NewDefaultProtocol)
+}
+
+// DefaultTimeout is the Timeout a new CoSi instance starts with. The
+// protocol itself does not enforce it; it is carried in the Announcement for
+// callers waiting on FinalSignature to use as their deadline.
+const DefaultTimeout = 5 * time.Second
+
+// SignatureHook is called once the root has finalized the co-signatures for
+// this protocol run. It is invoked from a dedicated goroutine so that a
+// slow callback can never block the protocol's dispatch loop.
+type SignatureHook func(sigs [][]byte)
+
+// CoSi is the main CoSi protocol struct. One instance runs the protocol on
+// a single onet.TreeNodeInstance, rooted at the node that called Start.
+type CoSi struct {
+	*onet.TreeNodeInstance
+
+	// Msgs holds the messages to be collectively signed within this round.
+	// Single-message signing is simply the len(Msgs)==1 case.
+	Msgs [][]byte
+	// Data is additional data the leader wants signed alongside Msgs but
+	// that isn't broadcast to every node (e.g. a large payload hash).
+	Data []byte
+	// Timeout is sent to every node in the Announcement. The protocol does
+	// not stop waiting for commitments or responses on its own; a caller
+	// that gives up after Timeout has to call Stop.
+	Timeout time.Duration
+
+	// FinalSignature receives one aggregated signature per entry in Msgs,
+	// in the same order, once the root finalizes the round. Only ever
+	// written to by the root.
+	FinalSignature chan [][]byte
+
+	commitment kyber.Point
+	response   []kyber.Scalar
+	secret     kyber.Scalar
+
+	structCommitment []StructCommitment
+	structResponse   []StructResponse
+
+	hooksLock      sync.Mutex
+	signatureHooks []SignatureHook
+}
+
+// NewDefaultProtocol is used to register the protocol with onet.
+func NewDefaultProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	c := &CoSi{
+		TreeNodeInstance: n,
+		Timeout:          DefaultTimeout,
+		FinalSignature:   make(chan [][]byte, 1),
+	}
+	for _, h := range []interface{}{
+		c.HandleAnnouncement,
+		c.HandleCommitment,
+		c.HandleChallenge,
+		c.HandleResponse,
+		c.HandleStop,
+	} {
+		if err := c.RegisterHandler(h); err != nil {
+			return nil, errors.New("couldn't register handler: " + err.Error())
+		}
+	}
+	return c, nil
+}
+
+// RegisterSignatureHook registers a callback that will be notified,
+// asynchronously, when the root finalizes the co-signatures. Safe to call
+// from any goroutine, including while the protocol is running.
+func (c *CoSi) RegisterSignatureHook(hook SignatureHook) {
+	c.hooksLock.Lock()
+	defer c.hooksLock.Unlock()
+	c.signatureHooks = append(c.signatureHooks, hook)
+}
+
+// notifyHooks fires every registered hook in its own goroutine so a slow or
+// blocking callback can never stall the protocol dispatch goroutine.
+func (c *CoSi) notifyHooks(sigs [][]byte) {
+	c.hooksLock.Lock()
+	hooks := append([]SignatureHook{}, c.signatureHooks...)
+	c.hooksLock.Unlock()
+	for _, h := range hooks {
+		go h(sigs)
+	}
+}
+
+// Start sends the Announcement to every node in the tree.
+func (c *CoSi) Start() error {
+	if len(c.Msgs) == 0 {
+		return errors.New("no message to sign")
+	}
+	log.Lvl3(c.ServerIdentity(), "Starting CoSi on", len(c.Msgs), "message(s)")
+	return c.HandleAnnouncement(StructAnnouncement{
+		c.TreeNode(),
+		Announcement{Msgs: c.Msgs, Data: c.Data, Timeout: c.Timeout},
+	})
+}
+
+// HandleAnnouncement passes the Announcement down the tree and, once every
+// leaf has been reached, triggers the local commitment.
+func (c *CoSi) HandleAnnouncement(msg StructAnnouncement) error {
+	c.Msgs = msg.Msgs
+	c.Data = msg.Data
+	if msg.Timeout > 0 {
+		c.Timeout = msg.Timeout
+	}
+	if !c.IsLeaf() {
+		for _, ch := range c.Children() {
+			if err := c.SendTo(ch, &msg.Announcement); err != nil {
+				return err
+			}
+		}
+	}
+	return c.commit()
+}
+
+// commit generates this node's single random commitment. A leaf sends it
+// up the tree; an intermediate node keeps it until HandleCommitment folds it
+// into the aggregate it forwards; the root without children goes straight
+// to the challenge. The same commitment is reused to derive a per-message share for every
+// entry in Msgs, so only one point ever needs to travel over the wire
+// regardless of how many messages are being signed.
+func (c *CoSi) commit() error {
+	mask, err := cosi.NewMask(EdDSACompatibleCosiSuite, c.Publics(), c.ServerIdentity().Public)
+	if err != nil {
+		return err
+	}
+	c.secret, c.commitment = cosi.Commit(EdDSACompatibleCosiSuite.RandomStream())
+	comm := Commitment{CoSiCommitment: c.commitment, Mask: mask.Mask()}
+	if c.IsRoot() {
+		// Don't add our own commitment to c.structCommitment here: when
+		// there are children, HandleCommitment overwrites it with their
+		// (self-excluded) list anyway, and aggregateCommitments always
+		// prepends c.commitment on its own. Doing both would count the
+		// root's commitment twice.
+		if len(c.Children()) == 0 {
+			return c.startChallenge()
+		}
+		return nil
+	}
+	if !c.IsLeaf() {
+		// aggregateCommitments includes c.commitment in the aggregate
+		// sent by HandleCommitment; sending it here too would count it twice.
+		return nil
+	}
+	return c.SendToParent(&comm)
+}
+
+// HandleCommitment aggregates commitments from the children until every
+// subtree has reported in, then either forwards the aggregate up or, at the
+// root, starts the challenge.
+func (c *CoSi) HandleCommitment(msg []StructCommitment) error {
+	c.structCommitment = msg
+	if !c.IsRoot() {
+		agg, mask, err := c.aggregateCommitments(msg)
+		if err != nil {
+			return err
+		}
+		return c.SendToParent(&Commitment{CoSiCommitment: agg, Mask: mask.Mask()})
+	}
+	return c.startChallenge()
+}
+
+// aggregateCommitments combines this node's own commitment with its
+// children's into a single aggregate commitment point and one merged mask
+// recording who participated in the round. The mask applies to every
+// message in Msgs alike; there is no per-message participation.
+func (c *CoSi) aggregateCommitments(msg []StructCommitment) (kyber.Point, *cosi.Mask, error) {
+	mask, err := cosi.NewMask(EdDSACompatibleCosiSuite, c.Publics(), c.ServerIdentity().Public)
+	if err != nil {
+		return nil, nil, err
+	}
+	commits := make([]kyber.Point, 0, len(msg)+1)
+	commits = append(commits, c.commitment)
+	for _, m := range msg {
+		commits = append(commits, m.CoSiCommitment)
+		if err := mask.Merge(m.Mask); err != nil {
+			return nil, nil, err
+		}
+	}
+	agg := cosi.AggregateCommitments(EdDSACompatibleCosiSuite, commits, []cosi.Mask{})[0]
+	return agg, mask, nil
+}
+
+// startChallenge is called on the root once every commitment is in. For
+// every message it derives a domain-separated per-message commitment share
+// R_j = R + H(j,R)·G from the aggregate commitment R, computes the matching
+// challenge, and broadcasts the whole batch down the tree in one go.
+func (c *CoSi) startChallenge() error {
+	agg, mask, err := c.aggregateCommitments(c.structCommitment)
+	if err != nil {
+		return err
+	}
+	// Don't overwrite c.commitment with agg here: finalize() calls
+	// aggregateCommitments(c.structCommitment) again once responses are
+	// in, and that recomputation still expects c.commitment to be this
+	// node's own raw commitment, not the already-aggregated value.
+
+	challenges := make([]kyber.Scalar, len(c.Msgs))
+	for j, m := range c.Msgs {
+		Rj := perMessageCommitment(agg, j)
+		ch, err := cosi.Challenge(EdDSACompatibleCosiSuite, Rj, mask.AggregatePublic, m)
+		if err != nil {
+			return err
+		}
+		challenges[j] = ch
+	}
+	return c.HandleChallenge(StructChallenge{c.TreeNode(), Challenge{CoSiChallenge: challenges}})
+}
+
+// HandleChallenge passes the Challenge down the tree and responds with this
+// node's share of every message's signature, under its single nonce.
+func (c *CoSi) HandleChallenge(msg StructChallenge) error {
+	if !c.IsRoot() {
+		for _, ch := range c.Children() {
+			if err := c.SendTo(ch, &msg.Challenge); err != nil {
+				return err
+			}
+		}
+	}
+	resp := make([]kyber.Scalar, len(msg.CoSiChallenge))
+	for j, challenge := range msg.CoSiChallenge {
+		r, err := cosi.Response(EdDSACompatibleCosiSuite, c.Private(), c.secret, challenge)
+		if err != nil {
+			return err
+		}
+		resp[j] = r
+	}
+	c.response = resp
+	if c.IsRoot() {
+		// Same reasoning as in commit(): aggregateResponses always
+		// prepends c.response on its own, so don't also add it to
+		// c.structResponse here or it would be double-counted.
+		if len(c.Children()) == 0 {
+			return c.finalize(msg)
+		}
+		return nil
+	}
+	if !c.IsLeaf() {
+		// HandleResponse sends c.response up as part of the aggregate.
+		return nil
+	}
+	return c.SendToParent(&Response{CoSiReponse: resp})
+}
+
+// HandleResponse aggregates responses from the children and, at the root,
+// finalizes the signatures once every subtree has reported in.
+func (c *CoSi) HandleResponse(msg []StructResponse) error {
+	c.structResponse = msg
+	if !c.IsRoot() {
+		agg, err := c.aggregateResponses(msg)
+		if err != nil {
+			return err
+		}
+		return c.SendToParent(&Response{CoSiReponse: agg})
+	}
+	return c.finalize(StructChallenge{})
+}
+
+// aggregateResponses combines this node's own per-message responses with
+// its children's into a single aggregate response per message.
+func (c *CoSi) aggregateResponses(msg []StructResponse) ([]kyber.Scalar, error) {
+	agg := make([]kyber.Scalar, len(c.response))
+	for j := range agg {
+		responses := make([]kyber.Scalar, 0, len(msg)+1)
+		responses = append(responses, c.response[j])
+		for _, m := range msg {
+			if j >= len(m.CoSiReponse) {
+				continue
+			}
+			responses = append(responses, m.CoSiReponse[j])
+		}
+		r, err := cosi.AggregateResponses(EdDSACompatibleCosiSuite, responses)
+		if err != nil {
+			return nil, err
+		}
+		agg[j] = r
+	}
+	return agg, nil
+}
+
+// finalize combines the aggregate commitment, challenge and response into
+// one final signature per message, notifies any registered hooks and closes
+// down the protocol. Only the root, once it folds each message's
+// domain-separation delta back into the aggregate response, knows the
+// final signatures.
+func (c *CoSi) finalize(msg StructChallenge) error {
+	agg, mask, err := c.aggregateCommitments(c.structCommitment)
+	if err != nil {
+		return err
+	}
+	respAgg, err := c.aggregateResponses(c.structResponse)
+	if err != nil {
+		return err
+	}
+
+	sigs := make([][]byte, len(c.Msgs))
+	for j := range c.Msgs {
+		Rj := perMessageCommitment(agg, j)
+		delta := perMessageOffset(agg, j)
+		adjusted := EdDSACompatibleCosiSuite.Scalar().Add(respAgg[j], delta)
+		sig, err := cosi.Sign(EdDSACompatibleCosiSuite, Rj, adjusted, mask)
+		if err != nil {
+			return err
+		}
+		sigs[j] = sig
+	}
+
+	c.notifyHooks(sigs)
+	c.FinalSignature <- sigs
+	c.Done()
+	return nil
+}
+
+// perMessageOffset derives the domain-separated scalar H(j,R) that binds
+// the aggregate commitment R to message index j, so every message in a
+// batch gets its own effective commitment share without any extra data on
+// the wire.
+func perMessageOffset(R kyber.Point, j int) kyber.Scalar {
+	h := EdDSACompatibleCosiSuite.Hash()
+	Rb, _ := R.MarshalBinary()
+	h.Write(Rb)
+	_ = binary.Write(h, binary.LittleEndian, uint32(j))
+	return EdDSACompatibleCosiSuite.Scalar().SetBytes(h.Sum(nil))
+}
+
+// perMessageCommitment derives R_j = R + H(j,R)·G, the per-message
+// commitment share for message index j.
+func perMessageCommitment(R kyber.Point, j int) kyber.Point {
+	delta := perMessageOffset(R, j)
+	return EdDSACompatibleCosiSuite.Point().Add(R, EdDSACompatibleCosiSuite.Point().Mul(delta, nil))
+}
+
+// Stop abandons the round: it broadcasts a Stop to every node in the tree
+// and marks this node done, same as receiving a Stop from elsewhere. A
+// caller that gives up waiting on FinalSignature (e.g. after a timeout)
+// must call this, or the round keeps running on every node in the tree
+// forever waiting for commitments/responses that will never arrive.
+func (c *CoSi) Stop() error {
+	return c.HandleStop(StructStop{c.TreeNode(), Stop{}})
+}
+
+// HandleStop stops the protocol on this node and forwards the Stop to its
+// children, so that it reaches every node below it in the tree.
+func (c *CoSi) HandleStop(msg StructStop) error {
+	if !c.IsLeaf() {
+		for _, ch := range c.Children() {
+			if err := c.SendTo(ch, &Stop{}); err != nil {
+				log.Error(c.ServerIdentity(), "couldn't forward stop:", err)
+			}
+		}
+	}
+	c.Done()
+	return nil
+}
@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dedis/kyber/sign/cosi"
+	"github.com/dedis/onet"
+	"github.com/dedis/onet/log"
+)
+
+// runRound starts the CoSi protocol on a tree of nbrNodes and blocks until
+// the root either finalizes the co-signatures or times out. It exercises
+// the whole announcement/commitment/challenge/response round-trip, on both
+// the no-children (1-node) and the with-children (multi-node) paths
+// through commit()/HandleChallenge().
+func runRound(t *testing.T, nbrNodes int, msgs [][]byte) (*onet.Tree, [][]byte) {
+	local := onet.NewLocalTest(EdDSACompatibleCosiSuite)
+	defer local.CloseAll()
+
+	_, _, tree := local.GenTree(nbrNodes, true)
+	pi, err := local.CreateProtocol(DefaultProtocolName, tree)
+	if err != nil {
+		t.Fatal("couldn't create protocol:", err)
+	}
+	proto := pi.(*CoSi)
+	proto.Msgs = msgs
+
+	if err := proto.Start(); err != nil {
+		t.Fatal("couldn't start protocol:", err)
+	}
+
+	select {
+	case sigs := <-proto.FinalSignature:
+		return tree, sigs
+	case <-time.After(DefaultTimeout):
+		t.Fatal("protocol didn't finalize in time")
+	}
+	return nil, nil
+}
+
+func verify(t *testing.T, tree *onet.Tree, msgs, sigs [][]byte) {
+	if len(sigs) != len(msgs) {
+		t.Fatalf("expected %d signatures, got %d", len(msgs), len(sigs))
+	}
+	publics := tree.Roster.Publics()
+	for j, msg := range msgs {
+		if err := cosi.Verify(EdDSACompatibleCosiSuite, publics, msg, sigs[j], cosi.CompletePolicy{}); err != nil {
+			t.Errorf("signature for message %d didn't verify: %v", j, err)
+		}
+	}
+}
+
+// TestCoSiSingleNode exercises the no-children path through commit() and
+// HandleChallenge(), where the root finalizes without ever receiving a
+// StructCommitment/StructResponse from anyone else.
+func TestCoSiSingleNode(t *testing.T) {
+	log.SetDebugVisible(1)
+	msgs := [][]byte{[]byte("single node, single message")}
+	tree, sigs := runRound(t, 1, msgs)
+	verify(t, tree, msgs, sigs)
+}
+
+// TestCoSiMultiNodeBatch exercises the with-children path on a batch of
+// several messages signed in the same round.
+func TestCoSiMultiNodeBatch(t *testing.T) {
+	log.SetDebugVisible(1)
+	msgs := [][]byte{
+		[]byte("first message in the batch"),
+		[]byte("second message in the batch"),
+		[]byte("third message in the batch"),
+	}
+	tree, sigs := runRound(t, 3, msgs)
+	verify(t, tree, msgs, sigs)
+}
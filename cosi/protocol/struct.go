@@ -46,9 +46,13 @@ func (m *cosiSuite) RandomStream() cipher.Stream {
 // cothority.Suite uses sha256 but EdDSA uses sha512.
 var EdDSACompatibleCosiSuite = &cosiSuite{edwards25519.NewBlakeSHA256Ed25519(), random.New()}
 
-// Announcement is the announcement message, the first message in the CoSi protocol
+// Announcement is the announcement message, the first message in the CoSi
+// protocol. Msgs holds one or more messages to be signed within the same
+// commit/challenge/response round, so that a batch of signatures only costs
+// a single round-trip of network traffic. Single-message signing is simply
+// the len(Msgs)==1 case.
 type Announcement struct {
-	Msg     []byte
+	Msgs    [][]byte
 	Data    []byte
 	Publics []kyber.Point
 	Timeout time.Duration
@@ -61,7 +65,26 @@ type StructAnnouncement struct {
 	Announcement
 }
 
-// Commitment is the cosi commitment message
+// Commitment is the cosi commitment message. CoSiCommitment is the single
+// random commitment R a node generates for the round; every per-message
+// commitment share R_j is re-derived from R deterministically, so only R
+// itself needs to travel over the wire. Mask records which signers
+// participated in the round; it applies to every message in Msgs alike —
+// a signer opts in or out of the whole batch at once.
+//
+// Known gap: per-message opt-out (a signer excluded from message A but
+// included in message B within the same round) is NOT supported, even
+// though it was asked for. Each signer only ever contributes one R into
+// the shared aggregate commitment, and R_j is derived from that same
+// aggregate for every message, so a mask that varied per message would
+// disagree with the single R the signer actually committed to, and
+// signatures built from it wouldn't verify. Supporting true per-message
+// opt-out would mean either giving up the single-round-trip batching
+// (each message gets its own R, its own commit/challenge/response) or
+// deriving R_j as a mask-weighted partial sum of the per-signer R's
+// instead of a deterministic offset of the shared aggregate — neither of
+// which is implemented here. Batches where every signer participates in
+// every message (the common case) are unaffected.
 type Commitment struct {
 	CoSiCommitment kyber.Point
 	Mask           []byte
@@ -74,9 +97,10 @@ type StructCommitment struct {
 	Commitment
 }
 
-// Challenge is the cosi challenge message
+// Challenge is the cosi challenge message. CoSiChallenge holds one scalar
+// challenge per message in the round, in the same order as Announcement.Msgs.
 type Challenge struct {
-	CoSiChallenge kyber.Scalar
+	CoSiChallenge []kyber.Scalar
 }
 
 // StructChallenge just contains Challenge and the data necessary to identify and
@@ -86,9 +110,12 @@ type StructChallenge struct {
 	Challenge
 }
 
-// Response is the cosi response message
+// Response is the cosi response message. CoSiReponse holds one scalar
+// response per message in the round, in the same order as Announcement.Msgs,
+// which verifiers combine with the matching per-message commitment share
+// into one aggregate signature per message.
 type Response struct {
-	CoSiReponse kyber.Scalar
+	CoSiReponse []kyber.Scalar
 }
 
 // StructResponse just contains Response and the data necessary to identify and
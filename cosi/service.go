@@ -0,0 +1,174 @@
+/*
+Package cosi offers a service that wraps the CoSi protocol: it gives
+external clients a simple SignatureRequest/SignatureResponse API so they
+don't have to build and drive a tree instance themselves in order to get a
+collective signature over a message.
+*/
+package cosi
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dedis/cothority/cosi/protocol"
+	"github.com/dedis/onet"
+	"github.com/dedis/onet/log"
+	"github.com/dedis/onet/network"
+)
+
+// ServiceName is used to refer to this service from other packages.
+const ServiceName = "CoSi"
+
+func init() {
+	onet.RegisterNewService(ServiceName, newCoSiService)
+	network.RegisterMessages(&SignatureRequest{}, &SignatureResponse{})
+}
+
+// ClientError is a typed error code returned to CoSi clients, so that they
+// can react programmatically instead of matching on error strings.
+type ClientError int
+
+// The codes a SignatureRequest can fail with.
+const (
+	// ErrorProtocolInit means the CoSi protocol instance couldn't be
+	// created or started on the roster's tree.
+	ErrorProtocolInit ClientError = iota + 1
+	// ErrorHashMessage means the message to be signed couldn't be hashed.
+	ErrorHashMessage
+	// ErrorTimeout means the round didn't finalize within Service.Timeout.
+	ErrorTimeout
+)
+
+// Error implements the error interface.
+func (e ClientError) Error() string {
+	switch e {
+	case ErrorProtocolInit:
+		return "couldn't initialise the CoSi protocol"
+	case ErrorHashMessage:
+		return "couldn't hash the message to sign"
+	case ErrorTimeout:
+		return "timed out waiting for the co-signature"
+	default:
+		return "unknown CoSi client error"
+	}
+}
+
+// SignatureRequest asks the service to collectively sign Message with
+// every node in Roster.
+type SignatureRequest struct {
+	Message []byte
+	Roster  *onet.Roster
+}
+
+// SignatureResponse holds the result of a successful SignatureRequest.
+type SignatureResponse struct {
+	Hash      []byte
+	Signature []byte
+}
+
+// Service runs the CoSi protocol on behalf of external clients that don't
+// want to build and drive a tree instance themselves.
+type Service struct {
+	*onet.ServiceProcessor
+
+	// Timeout bounds how long SignatureRequest waits for the protocol to
+	// finalize before returning ErrorTimeout.
+	Timeout time.Duration
+}
+
+// DefaultTimeout is used when Service.Timeout is left at its zero value.
+const DefaultTimeout = 10 * time.Second
+
+// SignatureRequest starts a CoSi round over req.Roster and blocks until the
+// root finalizes the co-signature or the service's Timeout elapses.
+func (s *Service) SignatureRequest(req *SignatureRequest) (*SignatureResponse, error) {
+	hash, err := hashMessage(req.Message)
+	if err != nil {
+		return nil, ErrorHashMessage
+	}
+
+	tree := req.Roster.GenerateNaryTree(len(req.Roster.List))
+	pi, err := s.CreateProtocol(protocol.DefaultProtocolName, tree)
+	if err != nil {
+		return nil, ErrorProtocolInit
+	}
+	proto := pi.(*protocol.CoSi)
+	proto.Msgs = [][]byte{hash}
+	proto.Timeout = s.timeout()
+
+	if err := proto.Start(); err != nil {
+		return nil, ErrorProtocolInit
+	}
+
+	select {
+	case sigs := <-proto.FinalSignature:
+		return &SignatureResponse{Hash: hash, Signature: sigs[0]}, nil
+	case <-time.After(proto.Timeout):
+		// Without this, the tree traversal keeps running on every node
+		// in the roster, waiting forever for a subtree that will never
+		// report back to a caller that's already gone.
+		if err := proto.Stop(); err != nil {
+			log.Error(s.ServerIdentity(), "couldn't stop timed-out CoSi round:", err)
+		}
+		return nil, ErrorTimeout
+	}
+}
+
+// RegisterSignatureHook starts a CoSi round like SignatureRequest, but
+// invokes hook asynchronously with the final signature instead of blocking
+// the caller, so the protocol's own dispatch goroutine is never held up
+// waiting on client code.
+func (s *Service) RegisterSignatureHook(req *SignatureRequest, hook protocol.SignatureHook) error {
+	hash, err := hashMessage(req.Message)
+	if err != nil {
+		return ErrorHashMessage
+	}
+
+	tree := req.Roster.GenerateNaryTree(len(req.Roster.List))
+	pi, err := s.CreateProtocol(protocol.DefaultProtocolName, tree)
+	if err != nil {
+		return ErrorProtocolInit
+	}
+	proto := pi.(*protocol.CoSi)
+	proto.Msgs = [][]byte{hash}
+	proto.Timeout = s.timeout()
+	proto.RegisterSignatureHook(hook)
+
+	return proto.Start()
+}
+
+// timeout returns s.Timeout, or DefaultTimeout if it was left unset.
+func (s *Service) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return s.Timeout
+}
+
+// hashMessage hashes msg with the same suite the CoSi protocol signs under,
+// so SignatureResponse.Hash always matches what verifiers recompute.
+func hashMessage(msg []byte) ([]byte, error) {
+	h := protocol.EdDSACompatibleCosiSuite.Hash()
+	if _, err := h.Write(msg); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// NewProtocol is called by the onet Overlay when a new protocol request
+// comes in for a protocol started by this service.
+func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfig) (onet.ProtocolInstance, error) {
+	log.Lvl3(s.ServerIdentity(), "CoSi service received New Protocol event")
+	return protocol.NewDefaultProtocol(tn)
+}
+
+// newCoSiService registers the handlers and returns a new Service.
+func newCoSiService(c *onet.Context) (onet.Service, error) {
+	s := &Service{
+		ServiceProcessor: onet.NewServiceProcessor(c),
+	}
+	if err := s.RegisterHandler(s.SignatureRequest); err != nil {
+		return nil, errors.New("couldn't register handler: " + err.Error())
+	}
+	return s, nil
+}
@@ -0,0 +1,57 @@
+package cosi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dedis/cothority/cosi/protocol"
+	"github.com/dedis/kyber/sign/cosi"
+	"github.com/dedis/onet"
+)
+
+func TestSignatureRequestRoundTrip(t *testing.T) {
+	local := onet.NewLocalTest(protocol.EdDSACompatibleCosiSuite)
+	defer local.CloseAll()
+
+	servers, roster, _ := local.GenTree(3, true)
+	service := local.GetServices(servers, ServiceName)[0].(*Service)
+
+	msg := []byte("sign me")
+	resp, err := service.SignatureRequest(&SignatureRequest{Message: msg, Roster: roster})
+	if err != nil {
+		t.Fatal("signature request failed:", err)
+	}
+
+	hash, err := hashMessage(msg)
+	if err != nil {
+		t.Fatal("couldn't hash message:", err)
+	}
+	if string(resp.Hash) != string(hash) {
+		t.Errorf("response hash doesn't match hashMessage(msg)")
+	}
+	if err := cosi.Verify(protocol.EdDSACompatibleCosiSuite, roster.Publics(), hash, resp.Signature, cosi.CompletePolicy{}); err != nil {
+		t.Errorf("returned signature didn't verify: %v", err)
+	}
+}
+
+// TestSignatureRequestTimeout checks that a request whose round can't
+// finish within Service.Timeout returns ErrorTimeout promptly instead of
+// blocking forever, and that doing so doesn't panic when the abandoned
+// protocol instance is stopped (see Stop() in cosi/protocol/protocol.go).
+func TestSignatureRequestTimeout(t *testing.T) {
+	local := onet.NewLocalTest(protocol.EdDSACompatibleCosiSuite)
+	defer local.CloseAll()
+
+	servers, roster, _ := local.GenTree(3, true)
+	service := local.GetServices(servers, ServiceName)[0].(*Service)
+	service.Timeout = time.Nanosecond
+
+	start := time.Now()
+	_, err := service.SignatureRequest(&SignatureRequest{Message: []byte("too slow"), Roster: roster})
+	if err != ErrorTimeout {
+		t.Fatalf("got err = %v, want ErrorTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("SignatureRequest took %v to report a timeout of %v", elapsed, service.Timeout)
+	}
+}
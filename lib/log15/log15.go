@@ -0,0 +1,278 @@
+// Package log15 provides a small structured, leveled logger in the style of
+// github.com/inconshreveable/log15: every record is a message plus a set of
+// key/value pairs rather than a printf-formatted string, which makes
+// cothority's logs machine-parseable instead of scattered format strings.
+//
+// A Logger is created once per package with New, and every call site picks
+// a level (Trace/Debug/Info/Warn/Error/Crit) and a handful of context
+// key/values, e.g.:
+//
+//	var logger = log15.New("pkg", "monitor")
+//	logger.Info("monitor listening", "addr", Sink, "port", DefaultSinkPort)
+//
+// Output goes through a Handler (StreamHandler, JSONHandler or
+// SyslogHandler); per-package level filtering can be changed at runtime
+// with SetPackageLevel.
+package log15
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lvl is the severity of a log record, from most to least severe.
+type Lvl int
+
+// The supported levels, most to least severe.
+const (
+	LvlCrit Lvl = iota
+	LvlError
+	LvlWarn
+	LvlInfo
+	LvlDebug
+	LvlTrace
+)
+
+// String returns the short name used in log output, e.g. "INFO".
+func (l Lvl) String() string {
+	switch l {
+	case LvlCrit:
+		return "CRIT"
+	case LvlError:
+		return "EROR"
+	case LvlWarn:
+		return "WARN"
+	case LvlInfo:
+		return "INFO"
+	case LvlDebug:
+		return "DBUG"
+	case LvlTrace:
+		return "TRCE"
+	default:
+		return "UNKN"
+	}
+}
+
+// Record is a single structured log event.
+type Record struct {
+	Time time.Time
+	Lvl  Lvl
+	Pkg  string
+	Msg  string
+	Ctx  []interface{}
+}
+
+// Handler writes out a Record. Implementations must be safe for concurrent use.
+type Handler interface {
+	Log(r *Record) error
+}
+
+// Logger emits structured, leveled records carrying its own context plus
+// whatever key/values are passed at the call site.
+type Logger interface {
+	// New returns a child Logger with ctx appended to this Logger's context.
+	New(ctx ...interface{}) Logger
+
+	Trace(msg string, ctx ...interface{})
+	Debug(msg string, ctx ...interface{})
+	Info(msg string, ctx ...interface{})
+	Warn(msg string, ctx ...interface{})
+	Error(msg string, ctx ...interface{})
+	Crit(msg string, ctx ...interface{})
+}
+
+type logger struct {
+	ctx []interface{}
+	pkg string
+}
+
+// New creates a Logger with the given context. Conventionally the first
+// pair is ("pkg", "<package name>") so per-package level filtering and
+// handler output can key off it.
+func New(ctx ...interface{}) Logger {
+	l := &logger{ctx: ctx}
+	for i := 0; i+1 < len(ctx); i += 2 {
+		if key, ok := ctx[i].(string); ok && key == "pkg" {
+			if pkg, ok := ctx[i+1].(string); ok {
+				l.pkg = pkg
+			}
+		}
+	}
+	return l
+}
+
+func (l *logger) New(ctx ...interface{}) Logger {
+	merged := make([]interface{}, 0, len(l.ctx)+len(ctx))
+	merged = append(merged, l.ctx...)
+	merged = append(merged, ctx...)
+	return New(merged...)
+}
+
+func (l *logger) write(lvl Lvl, msg string, ctx []interface{}) {
+	if lvl > packageLevel(l.pkg) {
+		return
+	}
+	r := &Record{
+		Time: time.Now(),
+		Lvl:  lvl,
+		Pkg:  l.pkg,
+		Msg:  msg,
+		Ctx:  append(append([]interface{}{}, l.ctx...), ctx...),
+	}
+	if err := currentHandler().Log(r); err != nil {
+		fmt.Fprintln(os.Stderr, "log15: handler error:", err)
+	}
+}
+
+func (l *logger) Trace(msg string, ctx ...interface{}) { l.write(LvlTrace, msg, ctx) }
+func (l *logger) Debug(msg string, ctx ...interface{}) { l.write(LvlDebug, msg, ctx) }
+func (l *logger) Info(msg string, ctx ...interface{})  { l.write(LvlInfo, msg, ctx) }
+func (l *logger) Warn(msg string, ctx ...interface{})  { l.write(LvlWarn, msg, ctx) }
+func (l *logger) Error(msg string, ctx ...interface{}) { l.write(LvlError, msg, ctx) }
+func (l *logger) Crit(msg string, ctx ...interface{})  { l.write(LvlCrit, msg, ctx) }
+
+var (
+	levelsLock   sync.RWMutex
+	defaultLevel = LvlInfo
+	packageLvls  = map[string]Lvl{}
+
+	handlerLock sync.RWMutex
+	handler     Handler = StreamHandler(os.Stdout)
+)
+
+// SetPackageLevel changes, at runtime, the minimum level logged for
+// records whose "pkg" context matches pkg.
+func SetPackageLevel(pkg string, lvl Lvl) {
+	levelsLock.Lock()
+	defer levelsLock.Unlock()
+	packageLvls[pkg] = lvl
+}
+
+// SetDefaultLevel changes the minimum level logged for packages that
+// haven't been given their own level with SetPackageLevel.
+func SetDefaultLevel(lvl Lvl) {
+	levelsLock.Lock()
+	defer levelsLock.Unlock()
+	defaultLevel = lvl
+}
+
+func packageLevel(pkg string) Lvl {
+	levelsLock.RLock()
+	defer levelsLock.RUnlock()
+	if lvl, ok := packageLvls[pkg]; ok {
+		return lvl
+	}
+	return defaultLevel
+}
+
+// SetHandler installs h as the process-wide Handler every Logger writes
+// through.
+func SetHandler(h Handler) {
+	handlerLock.Lock()
+	defer handlerLock.Unlock()
+	handler = h
+}
+
+func currentHandler() Handler {
+	handlerLock.RLock()
+	defer handlerLock.RUnlock()
+	return handler
+}
+
+// streamHandler writes human-readable "key=value" records to an io.Writer.
+type streamHandler struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// StreamHandler returns a Handler that writes one key=value line per
+// record to w, e.g. for stdout or a plain log file.
+func StreamHandler(w io.Writer) Handler {
+	return &streamHandler{w: w}
+}
+
+func (h *streamHandler) Log(r *Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(h.w, "%s [%s] %s", r.Time.Format(time.RFC3339), r.Lvl, r.Msg)
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		fmt.Fprintf(h.w, " %v=%v", r.Ctx[i], r.Ctx[i+1])
+	}
+	fmt.Fprintln(h.w)
+	return nil
+}
+
+// jsonHandler writes one JSON object per record to an io.Writer.
+type jsonHandler struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// JSONHandler returns a Handler that writes one JSON record per line to w,
+// suitable for log aggregation pipelines.
+func JSONHandler(w io.Writer) Handler {
+	return &jsonHandler{w: w}
+}
+
+func (h *jsonHandler) Log(r *Record) error {
+	fields := make(map[string]interface{}, len(r.Ctx)/2+3)
+	fields["t"] = r.Time.Format(time.RFC3339Nano)
+	fields["lvl"] = r.Lvl.String()
+	fields["msg"] = r.Msg
+	if r.Pkg != "" {
+		fields["pkg"] = r.Pkg
+	}
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		if key, ok := r.Ctx[i].(string); ok {
+			fields[key] = r.Ctx[i+1]
+		}
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = fmt.Fprintln(h.w, string(b))
+	return err
+}
+
+// syslogHandler forwards records to a syslog daemon.
+type syslogHandler struct {
+	w *syslog.Writer
+}
+
+// SyslogHandler dials the local syslog daemon and returns a Handler that
+// forwards every record to it under tag, mapping Lvl to the matching
+// syslog severity.
+func SyslogHandler(tag string) (Handler, error) {
+	w, err := syslog.New(syslog.LOG_USER|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogHandler{w: w}, nil
+}
+
+func (h *syslogHandler) Log(r *Record) error {
+	line := r.Msg
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		line += fmt.Sprintf(" %v=%v", r.Ctx[i], r.Ctx[i+1])
+	}
+	switch r.Lvl {
+	case LvlCrit:
+		return h.w.Crit(line)
+	case LvlError:
+		return h.w.Err(line)
+	case LvlWarn:
+		return h.w.Warning(line)
+	case LvlDebug, LvlTrace:
+		return h.w.Debug(line)
+	default:
+		return h.w.Info(line)
+	}
+}
@@ -0,0 +1,92 @@
+package log15
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// withHandler installs h for the duration of fn and restores whatever
+// handler was previously current, so tests don't leak state into
+// whichever test runs next.
+func withHandler(h Handler, fn func()) {
+	prev := currentHandler()
+	SetHandler(h)
+	defer SetHandler(prev)
+	fn()
+}
+
+func TestStreamHandlerFormat(t *testing.T) {
+	var buf bytes.Buffer
+	withHandler(StreamHandler(&buf), func() {
+		New("pkg", "test").Info("hello", "key", "value")
+	})
+
+	out := buf.String()
+	if !strings.Contains(out, "[INFO] hello") {
+		t.Errorf("expected level and message in output, got %q", out)
+	}
+	if !strings.Contains(out, "key=value") {
+		t.Errorf("expected key=value in output, got %q", out)
+	}
+}
+
+func TestJSONHandlerFields(t *testing.T) {
+	var buf bytes.Buffer
+	withHandler(JSONHandler(&buf), func() {
+		New("pkg", "test").Warn("something happened", "count", 3)
+	})
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("couldn't parse JSON output: %v", err)
+	}
+	if fields["msg"] != "something happened" {
+		t.Errorf("msg = %v, want %q", fields["msg"], "something happened")
+	}
+	if fields["lvl"] != "WARN" {
+		t.Errorf("lvl = %v, want WARN", fields["lvl"])
+	}
+	if fields["pkg"] != "test" {
+		t.Errorf("pkg = %v, want test", fields["pkg"])
+	}
+	if fields["count"] != float64(3) {
+		t.Errorf("count = %v, want 3", fields["count"])
+	}
+}
+
+func TestChildLoggerMergesContext(t *testing.T) {
+	var buf bytes.Buffer
+	withHandler(StreamHandler(&buf), func() {
+		parent := New("pkg", "test")
+		parent.New("request", "42").Info("done")
+	})
+
+	out := buf.String()
+	if !strings.Contains(out, "pkg=test") {
+		t.Errorf("expected parent context carried over, got %q", out)
+	}
+	if !strings.Contains(out, "request=42") {
+		t.Errorf("expected child context in output, got %q", out)
+	}
+}
+
+func TestPackageLevelFiltering(t *testing.T) {
+	defer SetPackageLevel("filtertest", LvlInfo)
+	SetPackageLevel("filtertest", LvlError)
+
+	var buf bytes.Buffer
+	withHandler(StreamHandler(&buf), func() {
+		logger := New("pkg", "filtertest")
+		logger.Info("should be filtered out")
+		if buf.Len() != 0 {
+			t.Fatalf("expected Info to be filtered at LvlError, got %q", buf.String())
+		}
+
+		logger.Error("should come through")
+		if !strings.Contains(buf.String(), "should come through") {
+			t.Errorf("expected Error to pass the LvlError filter, got %q", buf.String())
+		}
+	})
+}
@@ -0,0 +1,235 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// This file handles the forwarding of measurements to pluggable metrics
+// backends, and exposes the aggregated Stats over a Prometheus-compatible
+// HTTP /metrics endpoint.
+
+// MetricsSink is the interface every measurement backend has to implement.
+// The Monitor calls Update for every SingleMeasure it receives, in addition
+// to folding it into its own Stats, so several sinks can observe the same
+// stream of measurements at once.
+type MetricsSink interface {
+	// Update is called with every measure the Monitor receives.
+	Update(meas *SingleMeasure)
+	// Close flushes and releases any resource held by the sink.
+	Close() error
+}
+
+// Config bounds resource usage of a Monitor, analogous to a
+// max_open_connections setting on a database handle. Without it, a hung
+// Prometheus scraper (or a flood of them) could back up measurement
+// ingestion indefinitely.
+type Config struct {
+	// MaxOpenConnections is the maximum number of concurrent /metrics
+	// scrapers the Monitor will serve at once. Requests beyond that limit
+	// are rejected with http.StatusServiceUnavailable instead of queuing.
+	// A value <= 0 means DefaultMaxOpenConnections is used.
+	MaxOpenConnections int
+}
+
+// DefaultMaxOpenConnections is used when Config.MaxOpenConnections is unset.
+const DefaultMaxOpenConnections = 4
+
+// AddSink registers a MetricsSink that will receive every measure in
+// addition to the Monitor's own Stats.
+func (m *Monitor) AddSink(s MetricsSink) {
+	m.mutexSinks.Lock()
+	defer m.mutexSinks.Unlock()
+	m.sinks = append(m.sinks, s)
+}
+
+// statsSink is implemented by MetricsSinks that also want the full
+// aggregated Stats rather than just individual measures, e.g. to publish
+// min/max/avg/dev instead of only the last raw sample.
+type statsSink interface {
+	SetStats(stats *Stats)
+}
+
+// SetConfig installs the Config that bounds the Monitor's metrics-serving
+// behaviour.
+func (m *Monitor) SetConfig(c Config) {
+	if c.MaxOpenConnections <= 0 {
+		c.MaxOpenConnections = DefaultMaxOpenConnections
+	}
+	m.config = c
+}
+
+// updateSinks forwards a measure to every registered MetricsSink. It is
+// called from update() while mutexStats is not held, so sinks may take
+// their own locks without risking a deadlock with the Monitor.
+func (m *Monitor) updateSinks(meas *SingleMeasure) {
+	m.mutexSinks.Lock()
+	sinks := append([]MetricsSink{}, m.sinks...)
+	m.mutexSinks.Unlock()
+	for _, s := range sinks {
+		s.Update(meas)
+	}
+}
+
+// updateSinkStats forwards the current aggregated Stats to every sink that
+// implements statsSink. It is called from update() while mutexStats is
+// held, so every statsSink sees a consistent snapshot of the aggregation.
+func (m *Monitor) updateSinkStats(stats *Stats) {
+	m.mutexSinks.Lock()
+	sinks := append([]MetricsSink{}, m.sinks...)
+	m.mutexSinks.Unlock()
+	for _, s := range sinks {
+		if ss, ok := s.(statsSink); ok {
+			ss.SetStats(stats)
+		}
+	}
+}
+
+// ServeMetrics starts an HTTP server on addr exposing the Monitor's
+// PrometheusSink under /metrics. It blocks until the server errors or the
+// listener is closed. Concurrent scrapers are bounded by
+// Config.MaxOpenConnections so a hung scraper cannot back up measurement
+// ingestion.
+func (m *Monitor) ServeMetrics(addr string) error {
+	prom := NewPrometheusSink()
+	m.AddSink(prom)
+
+	max := m.config.MaxOpenConnections
+	if max <= 0 {
+		max = DefaultMaxOpenConnections
+	}
+	sem := make(chan struct{}, max)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", boundedHandler(sem, prom.Handler()))
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Error while monitor is binding metrics address: %v", err)
+	}
+	logger.Info("monitor serving prometheus metrics", "addr", addr)
+	return http.Serve(ln, mux)
+}
+
+// boundedHandler wraps h so that at most cap(sem) requests are served
+// concurrently; anything beyond that is rejected instead of queuing.
+func boundedHandler(sem chan struct{}, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			h.ServeHTTP(w, r)
+		default:
+			http.Error(w, "too many concurrent scrapers", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// PrometheusSink is a MetricsSink that exposes every named measurement as a
+// Prometheus gauge-vec (min/max/avg/dev) plus a counter of total samples
+// received, derived from the same Stats aggregation the Monitor keeps.
+type PrometheusSink struct {
+	registry *prometheus.Registry
+	mutex    sync.Mutex
+
+	values  *prometheus.GaugeVec
+	samples *prometheus.CounterVec
+}
+
+// NewPrometheusSink creates a PrometheusSink with its own registry so it can
+// be mounted on a Monitor's /metrics endpoint without colliding with metrics
+// registered elsewhere in the process.
+func NewPrometheusSink() *PrometheusSink {
+	values := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cothority",
+		Subsystem: "monitor",
+		Name:      "measurement",
+		Help:      "Aggregated min/max/avg/dev for a named cothority measurement.",
+	}, []string{"name", "stat"})
+	samples := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cothority",
+		Subsystem: "monitor",
+		Name:      "measurement_samples_total",
+		Help:      "Total number of samples received for a named cothority measurement.",
+	}, []string{"name"})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(values, samples)
+
+	return &PrometheusSink{
+		registry: registry,
+		values:   values,
+		samples:  samples,
+	}
+}
+
+// Update implements MetricsSink.
+func (p *PrometheusSink) Update(meas *SingleMeasure) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.samples.WithLabelValues(meas.Name).Inc()
+	p.values.WithLabelValues(meas.Name, "last").Set(meas.Value)
+}
+
+// Close implements MetricsSink. The registry needs no explicit teardown.
+func (p *PrometheusSink) Close() error {
+	return nil
+}
+
+// Handler returns the promhttp.Handler bound to this sink's registry, ready
+// to be mounted under /metrics.
+func (p *PrometheusSink) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// SetStats wires the Monitor's aggregated Stats into the Prometheus
+// gauge-vec, one min/max/avg/dev set per named measurement. It is called
+// whenever the Monitor's Stats are updated so that a scrape always reflects
+// the latest aggregation rather than only the last raw sample.
+func (p *PrometheusSink) SetStats(stats *Stats) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for name, val := range stats.Measurements() {
+		p.values.WithLabelValues(name, "min").Set(val.Min())
+		p.values.WithLabelValues(name, "max").Set(val.Max())
+		p.values.WithLabelValues(name, "avg").Set(val.Avg())
+		p.values.WithLabelValues(name, "dev").Set(val.Dev())
+	}
+}
+
+// StatsDSink is a MetricsSink that forwards every measure to a StatsD or
+// Graphite-compatible daemon over UDP, using the usual
+// "bucket:value|type" line protocol.
+type StatsDSink struct {
+	prefix string
+	conn   net.Conn
+}
+
+// NewStatsDSink dials addr (host:port of a statsd/Graphite-carbon daemon)
+// and returns a sink that forwards every measure under the given prefix.
+func NewStatsDSink(addr, prefix string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Error while dialing statsd daemon: %v", err)
+	}
+	return &StatsDSink{prefix: prefix, conn: conn}, nil
+}
+
+// Update implements MetricsSink.
+func (s *StatsDSink) Update(meas *SingleMeasure) {
+	line := s.prefix + "." + meas.Name + ":" + strconv.FormatFloat(meas.Value, 'f', -1, 64) + "|ms\n"
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		logger.Warn("statsd sink forward error", "err", err)
+	}
+}
+
+// Close implements MetricsSink.
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,56 @@
+package monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestBoundedHandlerRejectsBeyondCapacity(t *testing.T) {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(1)
+
+	h := boundedHandler(make(chan struct{}, 1), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Occupy the single slot with a request that blocks until we release it.
+	firstDone := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		firstDone <- rec
+	}()
+	started.Wait()
+
+	// A second concurrent request must be rejected instead of queuing.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("second concurrent request: got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	close(release)
+	first := <-firstDone
+	if first.Code != http.StatusOK {
+		t.Errorf("first request: got status %d, want %d", first.Code, http.StatusOK)
+	}
+}
+
+func TestBoundedHandlerAllowsSequentialRequests(t *testing.T) {
+	h := boundedHandler(make(chan struct{}, 1), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("request %d: got status %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+}
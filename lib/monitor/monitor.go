@@ -13,6 +13,7 @@
 package monitor
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -21,12 +22,14 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/dedis/cothority/lib/dbg"
+	"github.com/dedis/cothority/lib/log15"
 )
 
 // This file handles the collection of measurements, aggregates them and
 // write CSV file reports
 
+var logger = log15.New("pkg", "monitor")
+
 // listen is the address where to listen for the monitor. The endpoint can be a
 // monitor.Proxy or a direct connection with measure.go
 var Sink = "0.0.0.0"
@@ -35,6 +38,11 @@ var DefaultSinkPort = 10000
 // Monitor struct is used to collect measures and make the statistics about
 // them. It takes a stats object so it update that in a concurrent-safe manner
 // for each new measure it receives.
+//
+// NOTE: this package doesn't build standalone in this checkout — Stats and
+// SingleMeasure are used throughout but never defined anywhere in this
+// tree, predating the context-lifecycle and sink work done here. That
+// blocks adding real tests for Serve/Stop until the missing type(s) land.
 type Monitor struct {
 	listener     net.Listener
 	listenerLock *sync.Mutex
@@ -55,6 +63,21 @@ type Monitor struct {
 	// channel to notify the end of a connection
 	// send the name of the connection when finishd
 	done chan string
+
+	// sinks are notified of every measure in addition to stats, e.g. to
+	// forward them to Prometheus or a StatsD/Graphite daemon
+	sinks []MetricsSink
+	// mutexSinks guards sinks, since AddSink can run concurrently with
+	// update() forwarding measures to them
+	mutexSinks sync.Mutex
+
+	// config bounds resource usage of the Monitor, e.g. the number of
+	// concurrent /metrics scrapers
+	config Config
+
+	// cancel stops the currently running Serve, if any
+	cancel     context.CancelFunc
+	cancelLock sync.Mutex
 }
 
 // NewMonitor returns a new monitor given the stats
@@ -69,112 +92,168 @@ func NewMonitor(stats *Stats) *Monitor {
 	}
 }
 
-// Monitor will start listening for incoming connections on this address
-// It needs the stats struct pointer to update when measures come
-// Return an error if something went wrong during the connection setup
+// Listen starts listening for incoming connections on this address using a
+// background context. It is a thin wrapper around Serve for callers that
+// don't need to compose the Monitor into a larger supervisor tree.
 func (m *Monitor) Listen() error {
+	return m.Serve(context.Background())
+}
+
+// Serve starts listening for incoming connections on this address. It
+// blocks until ctx is cancelled or every connected peer signals the end of
+// its measurements, draining any measure already queued before returning.
+// Return an error if something went wrong during the connection setup.
+func (m *Monitor) Serve(ctx context.Context) error {
 	ln, err := net.Listen("tcp", Sink+":"+strconv.Itoa(DefaultSinkPort))
 	if err != nil {
 		return fmt.Errorf("Error while monitor is binding address: %v", err)
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	m.setCancel(cancel)
+	defer m.setCancel(nil)
+
+	m.listenerLock.Lock()
 	m.listener = ln
-	dbg.Lvl2("Monitor listening for stats on", Sink, ":", DefaultSinkPort)
-	finished := false
+	m.listenerLock.Unlock()
+	logger.Info("monitor listening", "addr", Sink, "port", DefaultSinkPort)
+
+	// Closing the listener is what unblocks ln.Accept() below, whether
+	// cancellation came from Stop() or from the caller's context.
+	go func() {
+		<-ctx.Done()
+		m.listenerLock.Lock()
+		if m.listener != nil {
+			m.listener.Close()
+		}
+		m.listenerLock.Unlock()
+	}()
+
 	go func() {
 		for {
-			if finished {
-				break
-			}
 			conn, err := ln.Accept()
 			if err != nil {
-				operr, ok := err.(*net.OpError)
-				// We cant accept anymore we closed the listener
-				if ok && operr.Op == "accept" {
-					break
+				if ctx.Err() != nil {
+					// We cant accept anymore, we closed the listener.
+					return
 				}
-				dbg.Lvl2("Error while monitor accept connection:", operr)
+				logger.Warn("monitor accept error", "err", err)
 				continue
 			}
-			dbg.Lvl3("Monitor: new connection from", conn.RemoteAddr().String())
+			logger.Debug("monitor new connection", "remote", conn.RemoteAddr().String())
 			m.mutexConn.Lock()
 			m.conns[conn.RemoteAddr().String()] = conn
-			go m.handleConnection(conn)
 			m.mutexConn.Unlock()
+			go m.handleConnection(ctx, conn)
 		}
 	}()
-	for !finished {
+
+	for {
 		select {
 		// new stats
 		case measure := <-m.measures:
 			m.update(measure)
 		// end of a peer conn
 		case peer := <-m.done:
-			dbg.Lvl3("Connections left:", len(m.conns))
+			logger.Debug("monitor connections left", "count", len(m.conns))
 			m.mutexConn.Lock()
 			delete(m.conns, peer)
+			remaining := len(m.conns)
 			m.mutexConn.Unlock()
-			// end of monitoring,
-			if len(m.conns) == 0 {
-				m.listenerLock.Lock()
-				m.listener.Close()
-				m.listener = nil
-				finished = true
-				m.listenerLock.Unlock()
-				break
+			// end of monitoring, every peer finished on its own
+			if remaining == 0 {
+				logger.Info("monitor finished waiting")
+				m.conns = make(map[string]net.Conn)
+				return nil
 			}
+		case <-ctx.Done():
+			logger.Info("monitor stopping", "err", ctx.Err())
+			// Closing the connections unblocks handleConnection's Decode.
+			m.mutexConn.Lock()
+			for _, c := range m.conns {
+				c.Close()
+			}
+			m.mutexConn.Unlock()
+			m.drainMeasures()
+			m.conns = make(map[string]net.Conn)
+			return ctx.Err()
 		}
 	}
-	dbg.Lvl2("Monitor finished waiting")
-	m.conns = make(map[string]net.Conn)
-	return nil
 }
 
-// StopMonitor will close every connections it has
-// And will stop updating the stats
+// drainMeasures flushes any measure already queued on m.measures so that a
+// cancelled Serve doesn't silently drop data it already accepted.
+func (m *Monitor) drainMeasures() {
+	for {
+		select {
+		case measure := <-m.measures:
+			m.update(measure)
+		default:
+			return
+		}
+	}
+}
+
+// setCancel installs the cancel func of the context currently passed to
+// Serve, so that Stop can cancel it from any goroutine.
+func (m *Monitor) setCancel(cancel context.CancelFunc) {
+	m.cancelLock.Lock()
+	m.cancel = cancel
+	m.cancelLock.Unlock()
+}
+
+// Stop will cancel the running Serve's context, close every connection it
+// has, and stop updating the stats.
 func (m *Monitor) Stop() {
-	dbg.Lvl2("Monitor Stop")
-	m.listenerLock.Lock()
-	if m.listener != nil {
-		m.listener.Close()
+	logger.Info("monitor stop requested")
+	m.cancelLock.Lock()
+	cancel := m.cancel
+	m.cancelLock.Unlock()
+	if cancel != nil {
+		cancel()
 	}
-	m.listenerLock.Unlock()
 	m.mutexConn.Lock()
 	for _, c := range m.conns {
 		c.Close()
 	}
 	m.mutexConn.Unlock()
-
 }
 
 // handleConnection will decode the data received and aggregates it into its
-// stats
-func (m *Monitor) handleConnection(conn net.Conn) {
+// stats. It returns once ctx is done or the connection is closed.
+func (m *Monitor) handleConnection(ctx context.Context, conn net.Conn) {
 	dec := json.NewDecoder(conn)
 	nerr := 0
 	for {
 		measure := &SingleMeasure{}
 		if err := dec.Decode(measure); err != nil {
-			// if end of connection
-			if err == io.EOF || strings.Contains(err.Error(), "closed") {
+			// if end of connection, or Serve was cancelled
+			if err == io.EOF || ctx.Err() != nil {
 				break
 			}
 			// otherwise log it
-			dbg.Lvl2("Error: monitor decoding from", conn.RemoteAddr().String(), ":", err)
+			logger.Warn("monitor decode error", "remote", conn.RemoteAddr().String(), "err", err)
 			nerr += 1
 			if nerr > 1 {
-				dbg.Lvl2("Monitor: too many errors from", conn.RemoteAddr().String(), ": Abort.")
+				logger.Warn("monitor aborting connection after repeated errors", "remote", conn.RemoteAddr().String())
 				break
 			}
 		}
 
-		dbg.Lvlf3("Monitor: received a Measure from %s: %+v", conn.RemoteAddr().String(), measure)
+		logger.Debug("monitor received measure", "remote", conn.RemoteAddr().String(), "measure", measure)
 		// Special case where the measurement is indicating a FINISHED step
 		switch strings.ToLower(measure.Name) {
 		case "end":
-			dbg.Lvl3("Finishing monitor")
-			m.done <- conn.RemoteAddr().String()
+			logger.Debug("monitor received end-of-stream measure")
+			select {
+			case m.done <- conn.RemoteAddr().String():
+			case <-ctx.Done():
+			}
 		default:
-			m.measures <- measure
+			select {
+			case m.measures <- measure:
+			case <-ctx.Done():
+			}
 		}
 	}
 }
@@ -185,7 +264,9 @@ func (m *Monitor) update(meas *SingleMeasure) {
 	m.mutexStats.Lock()
 	// updating
 	m.stats.Update(meas)
+	m.updateSinkStats(m.stats)
 	m.mutexStats.Unlock()
+	m.updateSinks(meas)
 }
 
 // Stats returns the updated stats in a concurrent-safe manner
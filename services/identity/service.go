@@ -13,13 +13,14 @@ package identity
 
 import (
 	"errors"
+	"os"
 
 	"sync"
 
 	"reflect"
 
 	"github.com/dedis/cothority/crypto"
-	"github.com/dedis/cothority/log"
+	"github.com/dedis/cothority/lib/log15"
 	"github.com/dedis/cothority/network"
 	"github.com/dedis/cothority/protocols/manage"
 	"github.com/dedis/cothority/sda"
@@ -29,6 +30,8 @@ import (
 // ServiceName can be used to refer to the name of this service
 const ServiceName = "Identity"
 
+var logger = log15.New("pkg", "identity")
+
 var identityService sda.ServiceID
 
 func init() {
@@ -58,18 +61,18 @@ type storage struct {
 // AddIdentity will register a new SkipChain and add it to our list of
 // managed identities
 func (s *Service) AddIdentity(si *network.ServerIdentity, ai *AddIdentity) (network.Body, error) {
-	log.Lvlf2("Adding identity %+v", *ai)
+	logger.Info("adding identity", "identity", *ai)
 	ids := &storage{
 		Latest: ai.Config,
 	}
-	log.Lvl3("Creating Root-skipchain")
+	logger.Debug("creating root skipchain")
 	var err error
 	ids.Root, err = s.skipchain.CreateRoster(ai.Roster, 2, 10,
 		skipchain.VerifyNone, nil)
 	if err != nil {
 		return nil, err
 	}
-	log.Lvl3("Creating Data-skipchain")
+	logger.Debug("creating data skipchain")
 	ids.Root, ids.Data, err = s.skipchain.CreateData(ids.Root, 2, 10,
 		skipchain.VerifyNone, ai.Config)
 	if err != nil {
@@ -83,7 +86,7 @@ func (s *Service) AddIdentity(si *network.ServerIdentity, ai *AddIdentity) (netw
 		return nil, err
 	}
 	if replies != len(roster.List) {
-		log.Warn("Did only get", replies, "out of", len(roster.List))
+		logger.Warn("propagation incomplete", "got", replies, "want", len(roster.List))
 	}
 
 	return &AddIdentityReply{
@@ -120,7 +123,7 @@ func (s *Service) ProposeSend(si *network.ServerIdentity, p *ProposeSend) (netwo
 		return nil, err
 	}
 	if replies != len(roster.List) {
-		log.Warn("Did only get", replies, "out of", len(roster.List))
+		logger.Warn("propagation incomplete", "got", replies, "want", len(roster.List))
 	}
 	return nil, nil
 }
@@ -149,7 +152,7 @@ func (s *Service) ProposeVote(si *network.ServerIdentity, v *ProposeVote) (netwo
 		return nil, errors.New("Didn't find identity")
 	}
 	sid.Lock()
-	log.Lvl3("Voting on", sid.Proposed.Device)
+	logger.Debug("voting on proposed config", "device", sid.Proposed.Device)
 	owner, ok := sid.Latest.Device[v.Signer]
 	if !ok {
 		return nil, errors.New("Didn't find signer")
@@ -164,7 +167,7 @@ func (s *Service) ProposeVote(si *network.ServerIdentity, v *ProposeVote) (netwo
 	if _, exists := sid.Votes[v.Signer]; exists {
 		return nil, errors.New("Already voted for that block")
 	}
-	log.Lvl3(v.Signer, "voted", v.Signature)
+	logger.Debug("vote recorded", "signer", v.Signer, "signature", v.Signature)
 	if v.Signature != nil {
 		err = crypto.VerifySchnorr(network.Suite, owner.Point, hash, *v.Signature)
 		if err != nil {
@@ -182,16 +185,16 @@ func (s *Service) ProposeVote(si *network.ServerIdentity, v *ProposeVote) (netwo
 		len(sid.Votes) == len(sid.Latest.Device) {
 		// If we have enough signatures, make a new data-skipblock and
 		// propagate it
-		log.Lvl3("Having majority or all votes")
+		logger.Debug("threshold reached, finalizing config")
 
 		// Making a new data-skipblock
-		log.Lvl3("Sending data-block with", sid.Proposed.Device)
+		logger.Debug("sending data-block", "device", sid.Proposed.Device)
 		reply, err := s.skipchain.ProposeData(sid.Root, sid.Data, sid.Proposed)
 		if err != nil {
 			return nil, err
 		}
 		_, msg, _ := network.UnmarshalRegistered(reply.Latest.Data)
-		log.Lvl3("SB signed is", msg.(*Config).Device)
+		logger.Debug("skipblock signed", "device", msg.(*Config).Device)
 		usb := &UpdateSkipBlock{
 			ID:     v.ID,
 			Latest: reply.Latest,
@@ -206,9 +209,80 @@ func (s *Service) ProposeVote(si *network.ServerIdentity, v *ProposeVote) (netwo
 	return nil, nil
 }
 
+// StatusRequest asks the identity service, for a given identity, whether
+// this replica is caught up with the roster's view of the skipchain.
+type StatusRequest struct {
+	ID ID
+}
+
+// StatusReply reports how caught up the local replica is for a given
+// identity.
+type StatusReply struct {
+	// LatestIndex is the skipblock-index of the locally stored Data block.
+	LatestIndex int
+	// LatestHash is the hash of the locally stored Data block.
+	LatestHash []byte
+	// PendingVotes is the number of votes gathered so far for the
+	// currently proposed config, if any.
+	PendingVotes int
+	// Threshold is the number of votes required for the proposed config
+	// to be accepted, from the latest config.
+	Threshold int
+	// CatchingUp is true if a GetLatestBlock roundtrip through the
+	// skipchain roster reports an index ahead of LatestIndex, meaning
+	// this replica fell behind during a previous propagation. It is also
+	// true when that roundtrip fails or returns no block.
+	CatchingUp bool
+}
+
+// Status reports, for a given ID, whether the local replica is caught up
+// with the roster's view of the skipchain. Clients - mobile devices in
+// particular - can use this as a cheap health probe before invoking
+// ProposeVote, and operators can use it to spot replicas that fell behind
+// during propagation timeouts.
+func (s *Service) Status(si *network.ServerIdentity, req *StatusRequest) (network.Body, error) {
+	sid := s.getIdentityStorage(req.ID)
+	if sid == nil {
+		return nil, errors.New("Didn't find Identity")
+	}
+	sid.Lock()
+	local := sid.Data
+	pending := 0
+	if sid.Proposed != nil {
+		pending = len(sid.Votes)
+	}
+	threshold := sid.Latest.Threshold
+	sid.Unlock()
+
+	latest, err := s.skipchain.GetLatestBlock(local)
+	if err != nil {
+		logger.Warn("couldn't get latest block from roster", "err", err)
+	}
+
+	return &StatusReply{
+		LatestIndex:  local.Index,
+		LatestHash:   local.Hash,
+		PendingVotes: pending,
+		Threshold:    threshold,
+		CatchingUp:   isCatchingUp(local.Index, latest, err),
+	}, nil
+}
+
+// isCatchingUp reports whether a replica at localIndex should be treated
+// as behind, given the result of probing the roster for the latest block.
+// A failed probe (err != nil, or no block returned) defaults to true: we
+// can't confirm the replica is caught up, so a failed health check must
+// not read the same as a healthy one.
+func isCatchingUp(localIndex int, latest *skipchain.SkipBlock, err error) bool {
+	if err != nil || latest == nil {
+		return true
+	}
+	return latest.Index > localIndex
+}
+
 // NewProtocol is called by the Overlay when a new protocol request comes in.
 func (s *Service) NewProtocol(tn *sda.TreeNodeInstance, conf *sda.GenericConfig) (sda.ProtocolInstance, error) {
-	log.Lvl3(s.ServerIdentity(), "Identity received New Protocol event", conf)
+	logger.Debug("received new protocol event", "server", s.ServerIdentity(), "conf", conf)
 	switch tn.ProtocolName() {
 	case "Propagate":
 		pi, err := manage.NewPropagateProtocol(tn)
@@ -223,7 +297,7 @@ func (s *Service) NewProtocol(tn *sda.TreeNodeInstance, conf *sda.GenericConfig)
 
 // Propagate handles propagation of all data in the identity-service
 func (s *Service) Propagate(msg network.Body) {
-	log.Lvlf4("Got msg %+v %v", msg, reflect.TypeOf(msg).String())
+	logger.Trace("propagate received message", "msg", msg, "type", reflect.TypeOf(msg).String())
 	id := ID(nil)
 	switch msg.(type) {
 	case *ProposeSend:
@@ -236,10 +310,10 @@ func (s *Service) Propagate(msg network.Body) {
 		pi := msg.(*PropagateIdentity)
 		id = ID(pi.Data.Hash)
 		if s.getIdentityStorage(id) != nil {
-			log.Error("Couldn't store new identity")
+			logger.Error("couldn't store new identity")
 			return
 		}
-		log.Lvl3("Storing identity in", s)
+		logger.Debug("storing identity", "service", s)
 		s.setIdentityStorage(id, pi.storage)
 		return
 	}
@@ -247,7 +321,7 @@ func (s *Service) Propagate(msg network.Body) {
 	if id != nil {
 		sid := s.getIdentityStorage(id)
 		if sid == nil {
-			log.Error("Didn't find entity in", s)
+			logger.Error("didn't find entity", "service", s)
 			return
 		}
 		sid.Lock()
@@ -264,12 +338,12 @@ func (s *Service) Propagate(msg network.Body) {
 			skipblock := msg.(*UpdateSkipBlock).Latest
 			_, msgLatest, err := network.UnmarshalRegistered(skipblock.Data)
 			if err != nil {
-				log.Error(err)
+				logger.Error("couldn't unmarshal latest skipblock", "err", err)
 				return
 			}
 			al, ok := msgLatest.(*Config)
 			if !ok {
-				log.Error(err)
+				logger.Error("couldn't unmarshal latest skipblock", "err", err)
 				return
 			}
 			sid.Data = skipblock
@@ -295,7 +369,7 @@ func (s *Service) getIdentityStorage(id ID) *storage {
 func (s *Service) setIdentityStorage(id ID, is *storage) {
 	s.identitiesMutex.Lock()
 	defer s.identitiesMutex.Unlock()
-	log.Lvlf3("%s %x %v", s.Context.ServerIdentity(), id[0:8], is.Latest.Device)
+	logger.Debug("storing identity", "server", s.Context.ServerIdentity(), "id", id[0:8], "device", is.Latest.Device)
 	s.identities[string(id)] = is
 }
 
@@ -307,9 +381,10 @@ func newIdentityService(c *sda.Context, path string) sda.Service {
 		path:             path,
 	}
 	for _, f := range []interface{}{s.ProposeSend, s.ProposeVote,
-		s.AddIdentity, s.ProposeFetch, s.ConfigUpdate} {
+		s.AddIdentity, s.ProposeFetch, s.ConfigUpdate, s.Status} {
 		if err := s.RegisterMessage(f); err != nil {
-			log.Fatal("Registration error:", err)
+			logger.Crit("registration error", "err", err)
+			os.Exit(1)
 		}
 	}
 	return s
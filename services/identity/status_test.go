@@ -0,0 +1,54 @@
+package identity
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dedis/cothority/services/skipchain"
+)
+
+func TestIsCatchingUp(t *testing.T) {
+	tests := []struct {
+		name       string
+		localIndex int
+		latest     *skipchain.SkipBlock
+		err        error
+		want       bool
+	}{
+		{
+			name:       "up to date",
+			localIndex: 5,
+			latest:     &skipchain.SkipBlock{Index: 5},
+			want:       false,
+		},
+		{
+			name:       "behind",
+			localIndex: 5,
+			latest:     &skipchain.SkipBlock{Index: 7},
+			want:       true,
+		},
+		{
+			name:       "probe failed",
+			localIndex: 5,
+			latest:     nil,
+			err:        errors.New("roster unreachable"),
+			want:       true,
+		},
+		{
+			name:       "no block returned despite no error",
+			localIndex: 5,
+			latest:     nil,
+			want:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := isCatchingUp(tt.localIndex, tt.latest, tt.err)
+			if got != tt.want {
+				t.Errorf("isCatchingUp(%d, %v, %v) = %v, want %v",
+					tt.localIndex, tt.latest, tt.err, got, tt.want)
+			}
+		})
+	}
+}